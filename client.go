@@ -18,6 +18,7 @@ package erfclient
 import (
 	"io/ioutil"
 	"os"
+	"sync"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
@@ -31,6 +32,11 @@ type ERFClient interface {
 
 	// Token returns the current client fingerprint to send to the remote service.
 	Token() ([]byte, error)
+
+	// Close stops any background resources held by the client, such as the signal handler
+	// installed via WithSignalCleanup. Callers should invoke it when the client is no longer
+	// needed. It is always safe to call, even if no such resources were started.
+	Close() error
 }
 
 // New creates a new ERFClient, storing the token data in the specified file. The token will refresh
@@ -39,11 +45,39 @@ func New(tokenFile string, refresh uint) (ERFClient, error) {
 	return newWithClock(tokenFile, refresh, clock.New())
 }
 
+// NewWithOptions is like New, but accepts Options controlling additional behaviour, such as
+// a SIGINT/SIGTERM handler that re-raises the signal (see WithSignalCleanup; it does not remove
+// the lock file) and signed tokens.
+func NewWithOptions(tokenFile string, refresh uint, opts ...Option) (ERFClient, error) {
+	return newWithStoreAndClock(NewFileStore(tokenFile), refresh, clock.New(), opts...)
+}
+
+// NewWithStore is like New, but allows the token persistence to be supplied explicitly, rather
+// than always reading and writing a file directly. See TokenStore.
+func NewWithStore(store TokenStore, refresh uint, opts ...Option) (ERFClient, error) {
+	return newWithStoreAndClock(store, refresh, clock.New(), opts...)
+}
+
 // newWithClock allows callers to specify the clock, as seen by the library
 func newWithClock(tokenFile string, refresh uint, clock clock.Clock) (ERFClient, error) {
-	c := client{file: tokenFile, refresh: refresh, clock: clock}
+	return newWithStoreAndClock(NewFileStore(tokenFile), refresh, clock)
+}
+
+// newWithStoreAndClock is the common constructor behind New, NewWithOptions, NewWithStore and
+// the test helpers: it applies opts, then grabs a token to trigger the store to be populated.
+func newWithStoreAndClock(store TokenStore, refresh uint, clk clock.Clock, opts ...Option) (ERFClient, error) {
+	o := &options{signing: defaultSigningConfig()}
+	for _, opt := range opts {
+		opt(o)
+	}
 
-	// Grab a token, to trigger file to be written
+	c := client{store: store, refresh: refresh, clock: clk, signing: o.signing}
+
+	if o.signalCleanup {
+		c.stopSignalCleanup = installSignalCleanup()
+	}
+
+	// Grab a token, to trigger the store to be populated
 	_, err := c.Token()
 
 	if err != nil {
@@ -54,27 +88,40 @@ func newWithClock(tokenFile string, refresh uint, clock clock.Clock) (ERFClient,
 
 // client is the implementation of ERFClient
 type client struct {
-	file    string
+	store   TokenStore
 	refresh uint
 	claims  *erf.ErfClaims
 	jwt     []byte
 
 	// clock can be overriden for unit testing
 	clock clock.Clock
+
+	// signing controls how tokens are signed and verified. Defaults to defaultSigningConfig.
+	signing signingConfig
+
+	// stopSignalCleanup stops the signal handler installed by WithSignalCleanup, if any.
+	stopSignalCleanup func()
+
+	// mu guards claims and jwt. Token is part of the ERFClient contract handed to
+	// NewHTTPClient, whose *http.Client is documented as safe for concurrent use, so Token
+	// must tolerate concurrent callers too.
+	mu sync.Mutex
 }
 
 // Token implements ERFClient.Token.
 func (c *client) Token() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	if c.claims == nil {
-		tokenBytes, err := readFile(c.file)
+		tokenBytes, err := c.store.Load()
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to read token file")
 		}
 
 		if tokenBytes != nil {
 			// Calls our claims.Valid() method.
-			token, claims, err := erf.ParseToken(tokenBytes)
+			token, claims, err := c.parseToken(tokenBytes)
 			if err != nil {
 				return nil, errors.Wrap(err, "failed to parse token")
 			}
@@ -98,6 +145,42 @@ func (c *client) Token() ([]byte, error) {
 	return c.jwt, nil
 }
 
+// Close implements ERFClient.Close.
+func (c *client) Close() error {
+	if c.stopSignalCleanup != nil {
+		c.stopSignalCleanup()
+		c.stopSignalCleanup = nil
+	}
+	return nil
+}
+
+// parseToken parses tokenBytes and verifies its signature against c.signing, rather than
+// against the fixed, unsigned scheme that erf.ParseToken assumes. c.signing.verify may hold more
+// than one candidate key, to tolerate a token signed before a key rotation; each is tried in turn,
+// and parseToken succeeds as soon as one of them verifies. A token that verifies against none of
+// them - whether tampered with or simply signed under a key that was never supplied - fails with
+// the error from the last candidate tried.
+func (c *client) parseToken(tokenBytes []byte) (*jwt.Token, *erf.ErfClaims, error) {
+	var lastErr error
+
+	for _, verify := range c.signing.verify {
+		claims := &erf.ErfClaims{}
+
+		token, err := jwt.ParseWithClaims(string(tokenBytes), claims, func(t *jwt.Token) (interface{}, error) {
+			if t.Method.Alg() != c.signing.method.Alg() {
+				return nil, errors.Errorf("unexpected signing method %q, expected %q", t.Method.Alg(), c.signing.method.Alg())
+			}
+			return verify, nil
+		})
+		if err == nil {
+			return token, claims, nil
+		}
+		lastErr = err
+	}
+
+	return nil, nil, lastErr
+}
+
 // readFile reads a file from disk. It returns nil if the file doesn't exist.
 func readFile(file string) ([]byte, error) {
 
@@ -114,35 +197,41 @@ func readFile(file string) ([]byte, error) {
 	return ioutil.ReadFile(file)
 }
 
-// persistNewToken creates a new token, stores the JWT in a cache and writes it to disk
+// persistNewToken creates a new token and stores it via c.store, basing the Previous/SequenceNo
+// chain on whatever is currently stored rather than c.claims, so that two processes sharing a
+// store cannot silently clobber each other's rotation.
 func (c *client) persistNewToken() error {
+	return c.store.Update(func(old []byte) ([]byte, error) {
 
-	now := c.clock.Now().Unix()
+		var prevClaims *erf.ErfClaims
+		if old != nil {
+			if _, claims, err := c.parseToken(old); err == nil {
+				prevClaims = claims
+			}
+		}
 
-	newClaims := &erf.ErfClaims{
-		IssuedAt:   erf.Int64Ptr(now),
-		ExpiresAt:  erf.Int64Ptr(now + int64(c.refresh)),
-		Subject:    erf.StringPtr(uuid.NewV4().String()),
-		SequenceNo: erf.Int64Ptr(0),
-		Previous:   erf.StringPtr(""),
-	}
+		now := c.clock.Now().Unix()
 
-	if c.claims != nil {
-		newClaims.SequenceNo = erf.Int64Ptr(*c.claims.SequenceNo + 1)
-		newClaims.Previous = erf.StringPtr(*c.claims.Subject)
-	}
+		newClaims := &erf.ErfClaims{
+			IssuedAt:   erf.Int64Ptr(now),
+			ExpiresAt:  erf.Int64Ptr(now + int64(c.refresh)),
+			Subject:    erf.StringPtr(uuid.NewV4().String()),
+			SequenceNo: erf.Int64Ptr(0),
+			Previous:   erf.StringPtr(""),
+		}
 
-	jwtString, err := jwt.NewWithClaims(jwt.SigningMethodNone, newClaims).SignedString(jwt.UnsafeAllowNoneSignatureType)
-	if err != nil {
-		return errors.WithMessage(err, "failed to create token")
-	}
+		if prevClaims != nil {
+			newClaims.SequenceNo = erf.Int64Ptr(*prevClaims.SequenceNo + 1)
+			newClaims.Previous = erf.StringPtr(*prevClaims.Subject)
+		}
 
-	err = ioutil.WriteFile(c.file, []byte(jwtString), 0600)
-	if err != nil {
-		return err
-	}
+		jwtString, err := jwt.NewWithClaims(c.signing.method, newClaims).SignedString(c.signing.key)
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to create token")
+		}
 
-	c.claims = newClaims
-	c.jwt = []byte(jwtString)
-	return nil
+		c.claims = newClaims
+		c.jwt = []byte(jwtString)
+		return c.jwt, nil
+	})
 }
@@ -18,6 +18,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -34,18 +35,13 @@ func TestReadBadFile(t *testing.T) {
 }
 
 func TestFirstToken(t *testing.T) {
-	dir, err := ioutil.TempDir("", "erf")
-	require.NoError(t, err)
-
-	defer os.RemoveAll(dir)
-
-	f := filepath.Join(dir, "newtokenfile")
+	store := NewMemoryStore()
 
 	mockClock := clock.NewMock()
 	mockClock.Freeze()
 
 	const refresh = 100
-	client, err := newWithClock(f, refresh, mockClock)
+	client, err := newWithStoreAndClock(store, refresh, mockClock)
 	require.NoError(t, err)
 
 	token, err := client.Token()
@@ -60,7 +56,8 @@ func TestFirstToken(t *testing.T) {
 	assert.Equal(t, int64(0), *claims.SequenceNo)
 	assert.NotEmpty(t, claims.Subject)
 
-	client2, err := newWithClock(f, refresh, mockClock)
+	// A second client sharing the same store should read the persisted token back correctly.
+	client2, err := newWithStoreAndClock(store, refresh, mockClock)
 	require.NoError(t, err)
 
 	token, err = client2.Token()
@@ -106,18 +103,11 @@ func TestNoRollover(t *testing.T) {
 }
 
 func TestRollover(t *testing.T) {
-	dir, err := ioutil.TempDir("", "erf")
-	require.NoError(t, err)
-
-	defer os.RemoveAll(dir)
-
-	f := filepath.Join(dir, "newtokenfile")
-
 	mockClock := clock.NewMock()
 	mockClock.Freeze()
 
 	const refresh = 100
-	client, err := newWithClock(f, refresh, mockClock)
+	client, err := newWithStoreAndClock(NewMemoryStore(), refresh, mockClock)
 	require.NoError(t, err)
 
 	token, err := client.Token()
@@ -155,3 +145,25 @@ func TestProperClock(t *testing.T) {
 		assert.Fail(t, "Wrong clock used")
 	}
 }
+
+// TestTokenConcurrentAccess exercises Token from many goroutines at once, the way the
+// *http.Client returned by NewHTTPClient is documented to be used. Run with -race: before the
+// client's mu field was added, this raced on claims/jwt.
+func TestTokenConcurrentAccess(t *testing.T) {
+	mockClock := clock.NewMock()
+	mockClock.Freeze()
+
+	client, err := newWithStoreAndClock(NewMemoryStore(), 100, mockClock)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.Token()
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,70 @@
+//go:build keyring
+// +build keyring
+
+// Copyright 2018 Thales UK Limited
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package erfclient: this file is only built with the "keyring" build tag, since it pulls in
+// the platform-specific OS credential store bindings used by zalando/go-keyring (Keychain on
+// macOS, Credential Manager on Windows, the Secret Service on Linux).
+package erfclient
+
+import "github.com/zalando/go-keyring"
+
+// KeyringStore is a TokenStore backed by the OS credential store, via zalando/go-keyring.
+type KeyringStore struct {
+	service string
+	user    string
+}
+
+// NewKeyringStore creates a KeyringStore that stores the token under the given service/user
+// pair in the OS credential store.
+func NewKeyringStore(service, user string) *KeyringStore {
+	return &KeyringStore{service: service, user: user}
+}
+
+// Load implements TokenStore.Load.
+func (s *KeyringStore) Load() ([]byte, error) {
+	v, err := keyring.Get(s.service, s.user)
+	if err != nil {
+		if err == keyring.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return []byte(v), nil
+}
+
+// Save implements TokenStore.Save.
+func (s *KeyringStore) Save(data []byte) error {
+	return keyring.Set(s.service, s.user, string(data))
+}
+
+// Update implements TokenStore.Update. The OS credential store does not offer a compare-and-swap
+// primitive, so this is not safe against concurrent rotation from another process; it is
+// provided for the common case of a single process owning the credential.
+func (s *KeyringStore) Update(fn func(old []byte) ([]byte, error)) error {
+	old, err := s.Load()
+	if err != nil {
+		return err
+	}
+
+	updated, err := fn(old)
+	if err != nil {
+		return err
+	}
+
+	return s.Save(updated)
+}
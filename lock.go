@@ -0,0 +1,79 @@
+// Copyright 2018 Thales UK Limited
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package erfclient
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// lockFileSuffix is appended to a token file's path to get the path of its sidecar lock file.
+const lockFileSuffix = ".lock"
+
+// lockMaxAttempts bounds the number of times withLock will try to acquire the lock before
+// giving up.
+const lockMaxAttempts = 8
+
+// lockBaseBackoff is the initial delay between lock acquisition attempts. It doubles after
+// each failed attempt.
+const lockBaseBackoff = 10 * time.Millisecond
+
+// fileLock is an OS-level advisory lock held via a sidecar file. Implementations are provided
+// per-platform (flock on Unix, LockFileEx on Windows).
+type fileLock interface {
+	// tryLock attempts to acquire the lock without blocking. It returns an error if the lock
+	// is already held elsewhere.
+	tryLock() error
+
+	// unlock releases the lock and closes the underlying sidecar file.
+	unlock() error
+}
+
+// withLock acquires an exclusive lock on the sidecar lock file for tokenFile, retrying with
+// bounded exponential backoff, then runs fn. The lock is released once fn returns, whether or
+// not it succeeds.
+func withLock(tokenFile string, fn func() error) error {
+	lock, err := newFileLock(tokenFile + lockFileSuffix)
+	if err != nil {
+		return errors.Wrap(err, "failed to open token lock file")
+	}
+
+	backoff := lockBaseBackoff
+	var lockErr error
+	for attempt := 0; attempt < lockMaxAttempts; attempt++ {
+		lockErr = lock.tryLock()
+		if lockErr == nil {
+			break
+		}
+
+		if attempt == lockMaxAttempts-1 {
+			break
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	if lockErr != nil {
+		_ = lock.unlock()
+		return errors.Wrap(lockErr, "failed to acquire token lock after retrying")
+	}
+
+	defer lock.unlock()
+
+	return fn()
+}
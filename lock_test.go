@@ -0,0 +1,74 @@
+// Copyright 2018 Thales UK Limited
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package erfclient
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithLockExcludesConcurrentCallers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erf-lock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "tokenfile")
+
+	var inCriticalSection int32
+	var overlapped bool
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := withLock(f, func() error {
+				if atomic.AddInt32(&inCriticalSection, 1) > 1 {
+					overlapped = true
+				}
+				defer atomic.AddInt32(&inCriticalSection, -1)
+				return nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	assert.False(t, overlapped, "withLock should serialise access to the critical section")
+}
+
+func TestWithLockPropagatesFnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erf-lock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "tokenfile")
+
+	err = withLock(f, func() error {
+		return errors.New("boom")
+	})
+	assert.EqualError(t, err, "boom")
+}
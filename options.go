@@ -0,0 +1,34 @@
+// Copyright 2018 Thales UK Limited
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package erfclient
+
+// options holds the behaviour assembled from Option values passed to NewWithOptions.
+type options struct {
+	signalCleanup bool
+	signing       signingConfig
+}
+
+// Option configures optional ERFClient behaviour. See NewWithOptions.
+type Option func(*options)
+
+// WithSignalCleanup installs a handler for SIGINT and SIGTERM, for the lifetime of the client,
+// that re-raises the signal once received so the process still terminates the way it would
+// without the handler installed.
+func WithSignalCleanup() Option {
+	return func(o *options) {
+		o.signalCleanup = true
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 Thales UK Limited
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package erfclient
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// installSignalCleanup installs a handler for SIGINT and SIGTERM that re-raises the signal once
+// received, so the process still terminates the way it would without the handler installed. It
+// returns a function that stops the handler; client.Close invokes it when the client is no
+// longer needed.
+//
+// This used to also remove the client's sidecar lock file on receipt of the signal, on the
+// theory that a process killed mid-critical-section left a stale lock behind for later callers
+// to wait out. That isn't how withLock's locks actually behave: both the flock (Unix) and
+// LockFileEx (Windows) implementations are tied to the open file handle, and are released
+// automatically by the OS as soon as the process exits, so there is no stale lock to clean up.
+// Worse, unlinking the lock file while it was genuinely still held let a second process acquire
+// a lock on a freshly created inode at the same path while the first was still inside its
+// critical section, defeating the mutual exclusion withLock exists to provide. See
+// TestSignalDuringHeldLockDoesNotBreakMutualExclusion in signal_test.go.
+func installSignalCleanup() (stop func()) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case sig, ok := <-sigCh:
+			if !ok {
+				return
+			}
+
+			signal.Stop(sigCh)
+
+			if p, err := os.FindProcess(os.Getpid()); err == nil {
+				_ = p.Signal(sig)
+			}
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		signal.Stop(sigCh)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 Thales UK Limited
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package erfclient
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// erfclientSignalTestHelperEnv, when set to a token file path, tells this test binary to behave
+// as the lock-holding subprocess used by TestSignalDuringHeldLockDoesNotBreakMutualExclusion
+// rather than running the normal test suite.
+const erfclientSignalTestHelperEnv = "ERFCLIENT_SIGNAL_TEST_TOKENFILE"
+
+func init() {
+	if tokenFile := os.Getenv(erfclientSignalTestHelperEnv); tokenFile != "" {
+		runSignalCleanupHelper(tokenFile)
+	}
+}
+
+// runSignalCleanupHelper installs the signal cleanup handler, then holds tokenFile's advisory
+// lock via withLock long enough for the parent test to observe it and signal the process -
+// standing in for a process that is sent SIGTERM mid-critical-section.
+func runSignalCleanupHelper(tokenFile string) {
+	stop := installSignalCleanup()
+	defer stop()
+
+	err := withLock(tokenFile, func() error {
+		if err := ioutil.WriteFile(tokenFile+".ready", []byte{}, 0600); err != nil {
+			return err
+		}
+		time.Sleep(30 * time.Second)
+		return nil
+	})
+
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// TestSignalDuringHeldLockDoesNotBreakMutualExclusion forks a subprocess that installs the
+// signal cleanup handler and then holds the sidecar lock via withLock, sends it SIGTERM while
+// the lock is still held, and asserts that a concurrent locker is excluded until the subprocess
+// has actually exited. This is the scenario that broke when the handler used to unconditionally
+// remove the lock file: a second locker could open a fresh inode at the same path and acquire
+// it while the first process was still inside its critical section.
+func TestSignalDuringHeldLockDoesNotBreakMutualExclusion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erf-signal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "tokenfile")
+	lockPath := tokenFile + lockFileSuffix
+	readyPath := tokenFile + ".ready"
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), erfclientSignalTestHelperEnv+"="+tokenFile)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	require.NoError(t, cmd.Start())
+
+	waitForFile(t, readyPath)
+
+	racingLock, err := newFileLock(lockPath)
+	require.NoError(t, err)
+	assert.Error(t, racingLock.tryLock(), "lock should still be held by the subprocess")
+	require.NoError(t, racingLock.unlock())
+
+	require.NoError(t, cmd.Process.Signal(syscall.SIGTERM))
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("subprocess did not exit after SIGTERM")
+	}
+
+	followingLock, err := newFileLock(lockPath)
+	require.NoError(t, err)
+	defer followingLock.unlock()
+	assert.NoError(t, followingLock.tryLock(), "lock should be free once the process holding it has exited")
+
+	_, err = os.Stat(lockPath)
+	assert.NoError(t, err, "the lock file is left in place; the OS already releases the underlying lock on process exit")
+}
+
+// waitForFile polls for path to exist, failing t if it does not appear in time.
+func waitForFile(t *testing.T, path string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s was never created", path)
+}
@@ -0,0 +1,172 @@
+// Copyright 2018 Thales UK Limited
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package erfclient
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/asn1"
+	"math/big"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// signingConfig captures how a client signs new tokens and verifies tokens read back from
+// storage.
+type signingConfig struct {
+	method jwt.SigningMethod
+	key    interface{} // passed to jwt.Token.SignedString
+
+	// verify holds the keys a stored token's signature may validate against, tried in order.
+	// It normally holds just the current key, but WithHMAC/WithSigner can be given previous
+	// keys too, so a token signed before a key rotation is still accepted rather than rejected
+	// outright.
+	verify []interface{}
+}
+
+// defaultSigningConfig is erfclient's historical behaviour: unsigned tokens. See WithUnsafeNone.
+func defaultSigningConfig() signingConfig {
+	return signingConfig{
+		method: jwt.SigningMethodNone,
+		key:    jwt.UnsafeAllowNoneSignatureType,
+		verify: []interface{}{jwt.UnsafeAllowNoneSignatureType},
+	}
+}
+
+// SigningOption is an Option that configures how ERFClient signs and verifies tokens.
+type SigningOption = Option
+
+// WithUnsafeNone configures the client to use jwt.SigningMethodNone, as erfclient has always
+// done. Anyone who can read or write the token file can forge tokens under this scheme. It is
+// the default when no signing SigningOption is supplied, and exists as an explicit option so
+// callers can opt into the unsafe behaviour deliberately rather than by omission.
+func WithUnsafeNone() SigningOption {
+	return func(o *options) {
+		o.signing = defaultSigningConfig()
+	}
+}
+
+// WithHMAC configures the client to sign tokens with the given symmetric key, using method (one
+// of jwt.SigningMethodHS256, jwt.SigningMethodHS384 or jwt.SigningMethodHS512). New tokens are
+// always signed with key; previousKeys are additionally accepted when verifying a token already
+// on disk, so a key can be rotated without every existing client rejecting the other's tokens
+// during the rollover. A tampered on-disk file is still rejected, since it won't verify against
+// key or any previousKeys.
+func WithHMAC(method *jwt.SigningMethodHMAC, key []byte, previousKeys ...[]byte) SigningOption {
+	return func(o *options) {
+		verify := make([]interface{}, 0, 1+len(previousKeys))
+		verify = append(verify, key)
+		for _, k := range previousKeys {
+			verify = append(verify, k)
+		}
+
+		o.signing = signingConfig{method: method, key: key, verify: verify}
+	}
+}
+
+// WithSigner configures the client to sign tokens with signer, using method (such as
+// jwt.SigningMethodRS256 or jwt.SigningMethodES256). New tokens are always signed with signer;
+// previousPublicKeys are additionally accepted when verifying a token already on disk, so a key
+// can be rotated the same way WithHMAC supports. A tampered on-disk file is still rejected,
+// since it won't verify against signer's public key or any previousPublicKeys.
+//
+// signer is only required to implement crypto.Signer, so it can be backed by an HSM or KMS that
+// never exposes the raw private key. jwt-go's own RSA and ECDSA signing methods can't do that:
+// their Sign implementations type-assert a concrete *rsa.PrivateKey or *ecdsa.PrivateKey, which
+// a crypto.Signer-only key can't satisfy. WithSigner wraps method in a signerSigningMethod that
+// drives signer directly instead.
+func WithSigner(method jwt.SigningMethod, signer crypto.Signer, previousPublicKeys ...crypto.PublicKey) SigningOption {
+	return func(o *options) {
+		verify := make([]interface{}, 0, 1+len(previousPublicKeys))
+		verify = append(verify, signer.Public())
+		for _, k := range previousPublicKeys {
+			verify = append(verify, k)
+		}
+
+		o.signing = signingConfig{method: wrapSignerMethod(method), key: signer, verify: verify}
+	}
+}
+
+// wrapSignerMethod wraps method, if it is jwt-go's RSA or ECDSA signing method, in a
+// signerSigningMethod so Sign works with a crypto.Signer rather than requiring the concrete
+// private key type jwt-go itself needs. Any other method (e.g. if WithSigner is mistakenly
+// passed an HMAC method) is returned unchanged, so Sign fails with jwt-go's own clear type error
+// rather than this package's.
+func wrapSignerMethod(method jwt.SigningMethod) jwt.SigningMethod {
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA:
+		return &signerSigningMethod{SigningMethod: method, hash: m.Hash}
+	case *jwt.SigningMethodECDSA:
+		return &signerSigningMethod{SigningMethod: method, hash: m.Hash, ecdsaKeySize: m.KeySize}
+	default:
+		return method
+	}
+}
+
+// signerSigningMethod wraps an RS256/ES256-style jwt.SigningMethod so Sign drives a
+// crypto.Signer directly, rather than (as jwt-go's own RSA/ECDSA methods do) type-asserting a
+// concrete *rsa.PrivateKey or *ecdsa.PrivateKey. Verify and Alg are promoted unchanged from the
+// embedded method: Verify only needs the public key, whose concrete type already matches what
+// jwt-go expects.
+type signerSigningMethod struct {
+	jwt.SigningMethod
+	hash crypto.Hash
+
+	// ecdsaKeySize is the fixed per-component byte width jwt-go's ES256/384/512 use to encode
+	// r and s. It is 0 for RSA methods, which need no such re-encoding.
+	ecdsaKeySize int
+}
+
+// Sign implements jwt.SigningMethod.Sign.
+func (m *signerSigningMethod) Sign(signingString string, key interface{}) (string, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return "", errors.Errorf("signerSigningMethod: expected a crypto.Signer, got %T", key)
+	}
+
+	hasher := m.hash.New()
+	hasher.Write([]byte(signingString))
+
+	sig, err := signer.Sign(rand.Reader, hasher.Sum(nil), m.hash)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign token")
+	}
+
+	if m.ecdsaKeySize > 0 {
+		if sig, err = ecdsaASN1ToJWS(sig, m.ecdsaKeySize); err != nil {
+			return "", err
+		}
+	}
+
+	return jwt.EncodeSegment(sig), nil
+}
+
+// ecdsaASN1ToJWS converts an ASN.1 DER-encoded ECDSA signature - what crypto.Signer.Sign
+// returns for an ECDSA key - into the fixed-width r||s encoding jwt-go and JWS expect.
+func ecdsaASN1ToJWS(der []byte, keyBytes int) ([]byte, error) {
+	var parsed struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &parsed); err != nil {
+		return nil, errors.Wrap(err, "failed to parse ECDSA signature")
+	}
+
+	out := make([]byte, 2*keyBytes)
+	parsed.R.FillBytes(out[:keyBytes])
+	parsed.S.FillBytes(out[keyBytes:])
+	return out, nil
+}
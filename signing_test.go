@@ -0,0 +1,198 @@
+// Copyright 2018 Thales UK Limited
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package erfclient
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stephanos/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signerOnly wraps a crypto.Signer without exposing whatever concrete private key type backs it,
+// standing in for an HSM- or KMS-backed key that never hands out its raw key material.
+type signerOnly struct {
+	crypto.Signer
+}
+
+func TestHMACRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "newtokenfile")
+	key := []byte("super-secret-key")
+
+	mockClock := clock.NewMock()
+	mockClock.Freeze()
+
+	c1, err := newWithStoreAndClock(NewFileStore(f), 100, mockClock, WithHMAC(jwt.SigningMethodHS256, key))
+	require.NoError(t, err)
+
+	token, err := c1.Token()
+	require.NoError(t, err)
+
+	// A second client, configured with the same key, should read the token back successfully.
+	c2, err := newWithStoreAndClock(NewFileStore(f), 100, mockClock, WithHMAC(jwt.SigningMethodHS256, key))
+	require.NoError(t, err)
+
+	token2, err := c2.Token()
+	require.NoError(t, err)
+	assert.Equal(t, token, token2)
+}
+
+func TestHMACKeyWithoutRotationRejected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "newtokenfile")
+
+	mockClock := clock.NewMock()
+	mockClock.Freeze()
+
+	_, err = newWithStoreAndClock(NewFileStore(f), 100, mockClock, WithHMAC(jwt.SigningMethodHS256, []byte("old-key")))
+	require.NoError(t, err)
+
+	// A client started with a different key than the one the file was signed with, and not told
+	// about the old key via previousKeys, must not silently accept it.
+	_, err = newWithStoreAndClock(NewFileStore(f), 100, mockClock, WithHMAC(jwt.SigningMethodHS256, []byte("new-key")))
+	assert.Error(t, err)
+}
+
+func TestHMACKeyRotationAccepted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "newtokenfile")
+	oldKey := []byte("old-key")
+	newKey := []byte("new-key")
+
+	mockClock := clock.NewMock()
+	mockClock.Freeze()
+
+	_, err = newWithStoreAndClock(NewFileStore(f), 100, mockClock, WithHMAC(jwt.SigningMethodHS256, oldKey))
+	require.NoError(t, err)
+
+	// A client started with the new key, but told oldKey is still a valid previousKeys entry,
+	// must accept the token the first client left behind rather than treating it as tampered.
+	c2, err := newWithStoreAndClock(NewFileStore(f), 100, mockClock, WithHMAC(jwt.SigningMethodHS256, newKey, oldKey))
+	require.NoError(t, err)
+
+	_, err = c2.Token()
+	assert.NoError(t, err)
+}
+
+// TestRSASignerRoundTrip exercises WithSigner with a signer that only implements crypto.Signer,
+// not *rsa.PrivateKey - the HSM/KMS use case WithSigner exists for. Before signerSigningMethod,
+// jwt.SigningMethodRSA.Sign type-asserted *rsa.PrivateKey directly and failed on a signer-only key
+// like this one.
+func TestRSASignerRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "newtokenfile")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	signer := signerOnly{key}
+
+	mockClock := clock.NewMock()
+	mockClock.Freeze()
+
+	c1, err := newWithStoreAndClock(NewFileStore(f), 100, mockClock, WithSigner(jwt.SigningMethodRS256, signer))
+	require.NoError(t, err)
+
+	token, err := c1.Token()
+	require.NoError(t, err)
+
+	// A second client, configured with the same signer, should read the token back successfully.
+	c2, err := newWithStoreAndClock(NewFileStore(f), 100, mockClock, WithSigner(jwt.SigningMethodRS256, signer))
+	require.NoError(t, err)
+
+	token2, err := c2.Token()
+	require.NoError(t, err)
+	assert.Equal(t, token, token2)
+}
+
+// TestECDSASignerRoundTrip is TestRSASignerRoundTrip's ES256 counterpart. It also covers the
+// ASN.1-to-JWS re-encoding signerSigningMethod does for ECDSA signatures: crypto.Signer.Sign
+// returns an ASN.1 DER SEQUENCE{r, s} for an ECDSA key, not the fixed-width r||s encoding jwt-go
+// and JWS expect.
+func TestECDSASignerRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "newtokenfile")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer := signerOnly{key}
+
+	mockClock := clock.NewMock()
+	mockClock.Freeze()
+
+	c1, err := newWithStoreAndClock(NewFileStore(f), 100, mockClock, WithSigner(jwt.SigningMethodES256, signer))
+	require.NoError(t, err)
+
+	token, err := c1.Token()
+	require.NoError(t, err)
+
+	// A second client, configured with the same signer, should read the token back successfully.
+	c2, err := newWithStoreAndClock(NewFileStore(f), 100, mockClock, WithSigner(jwt.SigningMethodES256, signer))
+	require.NoError(t, err)
+
+	token2, err := c2.Token()
+	require.NoError(t, err)
+	assert.Equal(t, token, token2)
+}
+
+func TestSignatureMismatchRejected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "newtokenfile")
+	key := []byte("super-secret-key")
+
+	mockClock := clock.NewMock()
+	mockClock.Freeze()
+
+	_, err = newWithStoreAndClock(NewFileStore(f), 100, mockClock, WithHMAC(jwt.SigningMethodHS256, key))
+	require.NoError(t, err)
+
+	// Tamper with the on-disk token.
+	contents, err := ioutil.ReadFile(f)
+	require.NoError(t, err)
+	contents[len(contents)-1] ^= 0xFF
+	require.NoError(t, ioutil.WriteFile(f, contents, 0600))
+
+	_, err = newWithStoreAndClock(NewFileStore(f), 100, mockClock, WithHMAC(jwt.SigningMethodHS256, key))
+	assert.Error(t, err)
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 Thales UK Limited
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package erfclient
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// TokenStore abstracts the persistence of the token file contents, so ERFClient is not tied to
+// the local filesystem.
+type TokenStore interface {
+	// Load returns the currently stored token bytes, or nil if none have been stored yet.
+	Load() ([]byte, error)
+
+	// Save overwrites the stored token bytes.
+	Save(data []byte) error
+
+	// Update atomically loads the current bytes, computes replacement bytes via fn, and saves
+	// them. Implementations must ensure no other Update call observes or overwrites an
+	// in-progress update, so that the Previous/SequenceNo chain maintained by the caller can
+	// never be split across concurrent rotations.
+	Update(fn func(old []byte) (new []byte, err error)) error
+}
+
+// FileStore is a TokenStore backed by a file on disk. Saves are atomic: the new contents are
+// written to a temporary file in the same directory, fsynced, then renamed into place, so a
+// crash mid-write cannot leave a truncated token that later fails to parse.
+type FileStore struct {
+	path string
+}
+
+// NewFileStore creates a FileStore that persists token bytes to path. If the file does not
+// exist it will be created on the first Save, provided the parent directory exists.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load implements TokenStore.Load.
+func (s *FileStore) Load() ([]byte, error) {
+	return readFile(s.path)
+}
+
+// Save implements TokenStore.Save.
+func (s *FileStore) Save(data []byte) error {
+	dir := filepath.Dir(s.path)
+
+	tmp, err := ioutil.TempFile(dir, ".erfclient-*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temp file")
+	}
+	tmpPath := tmp.Name()
+
+	if err := writeAndCloseTemp(tmp, data); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "failed to set temp file permissions")
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "failed to rename temp file into place")
+	}
+
+	return syncDir(dir)
+}
+
+// Update implements TokenStore.Update, serialising concurrent rotations with the same advisory
+// lock used elsewhere in the package.
+func (s *FileStore) Update(fn func(old []byte) ([]byte, error)) error {
+	return withLock(s.path, func() error {
+		old, err := s.Load()
+		if err != nil {
+			return err
+		}
+
+		updated, err := fn(old)
+		if err != nil {
+			return err
+		}
+
+		return s.Save(updated)
+	})
+}
+
+// writeAndCloseTemp writes data to f, fsyncs it and closes it.
+func writeAndCloseTemp(f *os.File, data []byte) error {
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write temp file")
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to sync temp file")
+	}
+
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close temp file")
+	}
+
+	return nil
+}
+
+// syncDir fsyncs dir, so a rename into it is durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to open parent directory")
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return errors.Wrap(err, "failed to sync parent directory")
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 Thales UK Limited
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package erfclient
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erf-store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := NewFileStore(filepath.Join(dir, "tokenfile"))
+
+	res, err := store.Load()
+	require.NoError(t, err)
+	assert.Nil(t, res)
+
+	require.NoError(t, store.Save([]byte("hello")))
+
+	res, err = store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), res)
+}
+
+func TestFileStoreSaveLeavesNoTempFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erf-store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := NewFileStore(filepath.Join(dir, "tokenfile"))
+	require.NoError(t, store.Save([]byte("hello")))
+
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "tokenfile", entries[0].Name())
+}
+
+func TestFileStoreUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erf-store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := NewFileStore(filepath.Join(dir, "tokenfile"))
+
+	err = store.Update(func(old []byte) ([]byte, error) {
+		assert.Nil(t, old)
+		return []byte("v1"), nil
+	})
+	require.NoError(t, err)
+
+	err = store.Update(func(old []byte) ([]byte, error) {
+		assert.Equal(t, []byte("v1"), old)
+		return []byte("v2"), nil
+	})
+	require.NoError(t, err)
+
+	res, err := store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v2"), res)
+}
+
+func TestMemoryStoreRoundTrip(t *testing.T) {
+	store := NewMemoryStore()
+
+	res, err := store.Load()
+	require.NoError(t, err)
+	assert.Nil(t, res)
+
+	require.NoError(t, store.Save([]byte("hello")))
+
+	res, err = store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), res)
+
+	err = store.Update(func(old []byte) ([]byte, error) {
+		assert.Equal(t, []byte("hello"), old)
+		return []byte("world"), nil
+	})
+	require.NoError(t, err)
+
+	res, err = store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("world"), res)
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 Thales UK Limited
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package erfclient
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// HeaderStyle selects which header Transport injects the ERF token into.
+type HeaderStyle int
+
+const (
+	// AuthorizationBearer sets "Authorization: Bearer <token>". This is the default.
+	AuthorizationBearer HeaderStyle = iota
+
+	// ERFTokenHeader sets "X-ERF-Token: <token>".
+	ERFTokenHeader
+)
+
+// TransportOption configures a Transport.
+type TransportOption func(*Transport)
+
+// WithHeaderStyle selects which header Transport injects the token into. Defaults to
+// AuthorizationBearer.
+func WithHeaderStyle(style HeaderStyle) TransportOption {
+	return func(t *Transport) {
+		t.headerStyle = style
+	}
+}
+
+// WithBase sets the http.RoundTripper that Transport wraps. Defaults to http.DefaultTransport.
+func WithBase(base http.RoundTripper) TransportOption {
+	return func(t *Transport) {
+		t.base = base
+	}
+}
+
+// Transport wraps an http.RoundTripper, injecting the current token from an ERFClient into
+// every outbound request. Token caching and refresh are delegated entirely to the underlying
+// ERFClient, so Transport itself holds no state beyond its configuration.
+type Transport struct {
+	client      ERFClient
+	base        http.RoundTripper
+	headerStyle HeaderStyle
+}
+
+// NewTransport creates a Transport that injects tokens from c into requests sent through its
+// base RoundTripper (http.DefaultTransport unless overridden with WithBase).
+func NewTransport(c ERFClient, opts ...TransportOption) *Transport {
+	t := &Transport{client: c, base: http.DefaultTransport}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// NewHTTPClient returns an *http.Client whose RoundTripper is a Transport wrapping c, so callers
+// no longer need to fetch and attach the token themselves.
+func NewHTTPClient(c ERFClient, opts ...TransportOption) *http.Client {
+	return &http.Client{Transport: NewTransport(c, opts...)}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.token(req.Context())
+	if err != nil {
+		return nil, errors.Wrap(err, "erfclient: failed to obtain token")
+	}
+
+	req = req.Clone(req.Context())
+
+	switch t.headerStyle {
+	case ERFTokenHeader:
+		req.Header.Set("X-ERF-Token", string(token))
+	default:
+		req.Header.Set("Authorization", "Bearer "+string(token))
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// contextTokener is implemented by ERFClient implementations that can honour context
+// cancellation while obtaining a token, such as while waiting on the token file's lock.
+type contextTokener interface {
+	TokenContext(ctx context.Context) ([]byte, error)
+}
+
+// token obtains the current token from t.client, honouring ctx cancellation if the client
+// supports it.
+func (t *Transport) token(ctx context.Context) ([]byte, error) {
+	if ct, ok := t.client.(contextTokener); ok {
+		return ct.TokenContext(ctx)
+	}
+	return t.client.Token()
+}
+
+// TokenContext is like Token, but returns early with ctx.Err() if ctx is done before a token
+// becomes available - in particular, while waiting to acquire the token file's lock.
+func (c *client) TokenContext(ctx context.Context) ([]byte, error) {
+	type result struct {
+		token []byte
+		err   error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		token, err := c.Token()
+		ch <- result{token: token, err: err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.token, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
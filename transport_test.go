@@ -0,0 +1,134 @@
+// Copyright 2018 Thales UK Limited
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package erfclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stephanos/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportInjectsAndRefreshesToken(t *testing.T) {
+	var gotHeaders []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeaders = append(gotHeaders, r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	mockClock := clock.NewMock()
+	mockClock.Freeze()
+
+	const refresh = 100
+	c, err := newWithStoreAndClock(NewMemoryStore(), refresh, mockClock)
+	require.NoError(t, err)
+
+	httpClient := NewHTTPClient(c)
+
+	resp, err := httpClient.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	mockClock.Add((refresh + 1) * time.Second)
+
+	resp, err = httpClient.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Len(t, gotHeaders, 2)
+	assert.True(t, len(gotHeaders[0]) > len("Bearer "))
+	assert.NotEqual(t, gotHeaders[0], gotHeaders[1], "token should have refreshed")
+}
+
+func TestTransportHeaderStyle(t *testing.T) {
+	var gotHeader string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Get("X-ERF-Token")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	mockClock := clock.NewMock()
+	mockClock.Freeze()
+
+	c, err := newWithStoreAndClock(NewMemoryStore(), 100, mockClock)
+	require.NoError(t, err)
+
+	httpClient := NewHTTPClient(c, WithHeaderStyle(ERFTokenHeader))
+
+	resp, err := httpClient.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.NotEmpty(t, gotHeader)
+}
+
+// TestHTTPClientConcurrentRequests exercises the *http.Client returned by NewHTTPClient from many
+// goroutines at once, matching its documented "safe for concurrent use" contract. Run with -race:
+// before the client's mu field was added, this raced on claims/jwt inside Token.
+func TestHTTPClientConcurrentRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	mockClock := clock.NewMock()
+	mockClock.Freeze()
+
+	c, err := newWithStoreAndClock(NewMemoryStore(), 100, mockClock)
+	require.NoError(t, err)
+
+	httpClient := NewHTTPClient(c)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := httpClient.Get(server.URL)
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+type erroringClient struct{}
+
+func (erroringClient) Token() ([]byte, error) {
+	return nil, assert.AnError
+}
+
+func (erroringClient) Close() error {
+	return nil
+}
+
+func TestTransportSurfacesTokenError(t *testing.T) {
+	httpClient := NewHTTPClient(erroringClient{})
+
+	_, err := httpClient.Get("http://example.invalid")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to obtain token")
+}